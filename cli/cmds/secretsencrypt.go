@@ -0,0 +1,100 @@
+package cmds
+
+import (
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const SecretsEncryptCommand = "secrets-encrypt"
+
+var (
+	KeyNameFlag = cli.StringFlag{
+		Name:  "name",
+		Usage: "Name of the encryption key",
+	}
+	KeyTypeFlag = cli.StringFlag{
+		Name:  "type",
+		Usage: "Provider the key belongs to, aescbc or aesgcm",
+		Value: "aescbc",
+	}
+	ToProviderFlag = cli.StringFlag{
+		Name:  "to-provider",
+		Usage: "Name of an existing key to promote to primary before reencrypting",
+	}
+	RetireProviderFlag = cli.StringFlag{
+		Name:  "retire",
+		Usage: "Name of a provider to remove once the reencrypt finishes: a KMS provider's name, or the first key name of an aescbc/aesgcm provider",
+	}
+	KMSNameFlag = cli.StringFlag{
+		Name:  "name",
+		Usage: "Name of the KMS provider",
+	}
+	KMSEndpointFlag = cli.StringFlag{
+		Name:  "endpoint",
+		Usage: "gRPC endpoint of the KMS v2 plugin",
+	}
+	KMSTimeoutFlag = cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Timeout for calls to the KMS plugin",
+		Value: 3 * time.Second,
+	}
+)
+
+// NewSecretsEncryptKeysCommands returns the `secrets-encrypt keys` command group,
+// which manages the EncryptionConfiguration providers explicitly rather than only
+// via the controller's implicit prepend/trim-last behavior.
+func NewSecretsEncryptKeysCommands(list, add, remove func(ctx *cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "keys",
+		Usage: "Manage secrets encryption keys",
+		Subcommands: []cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List all configured encryption keys, active key first",
+				Action: list,
+			},
+			{
+				Name:   "add",
+				Usage:  "Add a new encryption key",
+				Flags:  []cli.Flag{KeyNameFlag, KeyTypeFlag},
+				Action: add,
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove an encryption key by name",
+				ArgsUsage: "<name>",
+				Action:    remove,
+			},
+		},
+	}
+}
+
+// NewSecretsEncryptKMSCommand returns the `secrets-encrypt kms` command group,
+// which configures a KMS v2 provider ahead of the existing local AES keys, making
+// it the primary provider while the AES keys stay in place for decryption.
+func NewSecretsEncryptKMSCommand(add func(ctx *cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:  "kms",
+		Usage: "Manage the KMS v2 encryption provider",
+		Subcommands: []cli.Command{
+			{
+				Name:   "add",
+				Usage:  "Configure a KMS v2 provider ahead of the existing keys",
+				Flags:  []cli.Flag{KMSNameFlag, KMSEndpointFlag, KMSTimeoutFlag},
+				Action: add,
+			},
+		},
+	}
+}
+
+// NewSecretsEncryptRotateCommand returns the `secrets-encrypt rotate` command,
+// which optionally promotes a key to primary before driving a full reencrypt.
+func NewSecretsEncryptRotateCommand(action func(ctx *cli.Context) error) cli.Command {
+	return cli.Command{
+		Name:   "rotate",
+		Usage:  "Promote a key to primary and reencrypt all secrets with it",
+		Flags:  []cli.Flag{ToProviderFlag, RetireProviderFlag},
+		Action: action,
+	}
+}
@@ -0,0 +1,119 @@
+package secretsencrypt
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	"github.com/k3s-io/k3s/pkg/secretsencrypt"
+	"github.com/rancher/wrangler/pkg/generated/controllers/core"
+	coreclient "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// runtimeAndNodes resolves the on-disk encryption config and a NodeController
+// talking to the local apiserver, using the same --data-dir convention as the
+// other secrets-encrypt subcommands (status/enable/prepare/rotate/reencrypt).
+func runtimeAndNodes(clx *cli.Context) (*config.ControlRuntime, coreclient.NodeController, error) {
+	dataDir := clx.String("data-dir")
+	if dataDir == "" {
+		dataDir = "/var/lib/rancher/k3s"
+	}
+
+	runtime := &config.ControlRuntime{
+		EncryptionConfig: filepath.Join(dataDir, "server", "cred", "encryption-config.json"),
+	}
+
+	kubeconfig := filepath.Join(dataDir, "server", "cred", "admin.kubeconfig")
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load admin kubeconfig: %v", err)
+	}
+	coreFactory, err := core.NewFactoryFromConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build node client: %v", err)
+	}
+
+	return runtime, coreFactory.Core().V1().Node(), nil
+}
+
+// KeysList implements `k3s secrets-encrypt keys list`.
+func KeysList(clx *cli.Context) error {
+	runtime, _, err := runtimeAndNodes(clx)
+	if err != nil {
+		return err
+	}
+	keys, err := secretsencrypt.ListKeys(runtime)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if i == 0 {
+			fmt.Printf("%s (active)\n", key.Name)
+			continue
+		}
+		fmt.Println(key.Name)
+	}
+	return nil
+}
+
+// KeysAdd implements `k3s secrets-encrypt keys add --name=<name> --type=<aescbc|aesgcm>`.
+func KeysAdd(clx *cli.Context) error {
+	runtime, _, err := runtimeAndNodes(clx)
+	if err != nil {
+		return err
+	}
+	name := clx.String("name")
+	keyType := secretsencrypt.KeyType(clx.String("type"))
+	if err := secretsencrypt.AddKey(runtime, name, keyType); err != nil {
+		return err
+	}
+	logrus.Infof("Added encryption key %s", name)
+	return nil
+}
+
+// KeysRemove implements `k3s secrets-encrypt keys remove <name>`.
+func KeysRemove(clx *cli.Context) error {
+	name := clx.Args().First()
+	if name == "" {
+		return fmt.Errorf("key name is required")
+	}
+	runtime, _, err := runtimeAndNodes(clx)
+	if err != nil {
+		return err
+	}
+	if err := secretsencrypt.RemoveKey(runtime, name); err != nil {
+		return err
+	}
+	logrus.Infof("Removed encryption key %s", name)
+	return nil
+}
+
+// Rotate implements `k3s secrets-encrypt rotate [--to-provider=<name>]`. When
+// --to-provider is set, that key is promoted to primary before the reencrypt is
+// requested; otherwise the currently configured primary key is used as-is.
+func Rotate(clx *cli.Context) error {
+	runtime, nodes, err := runtimeAndNodes(clx)
+	if err != nil {
+		return err
+	}
+	if name := clx.String("to-provider"); name != "" {
+		if err := secretsencrypt.PromoteKey(runtime, name); err != nil {
+			return err
+		}
+		logrus.Infof("Promoted encryption key %s to primary", name)
+	}
+	if name := clx.String("retire"); name != "" {
+		if err := secretsencrypt.MarkProviderRetiring(runtime, name); err != nil {
+			return err
+		}
+		logrus.Infof("Provider %s will be removed once the reencrypt completes", name)
+	}
+	if err := secretsencrypt.TriggerReencrypt(nodes, runtime); err != nil {
+		return err
+	}
+	logrus.Infof("Requested reencrypt of all secrets")
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"context"
+	"time"
+)
+
+// Control holds the runtime configuration for a k3s server. Only the fields
+// touched by the secretsencrypt package are declared here.
+type Control struct {
+	Runtime *ControlRuntime
+
+	// EncryptSkip reverts a reencrypt back to its bootstrap annotation instead of
+	// completing it, used to validate a reencrypt without committing to it.
+	EncryptSkip bool
+
+	// EncryptionKeyRenewPeriod is how often the reencrypt controller generates
+	// and activates a new encryption key on its own. Zero disables rotation.
+	EncryptionKeyRenewPeriod time.Duration
+
+	// EncryptionReencryptWorkers bounds how many secrets updateSecrets rewrites
+	// concurrently. Zero uses defaultReencryptWorkers.
+	EncryptionReencryptWorkers int
+
+	// EncryptionKMSName and EncryptionKMSEndpoint configure a KMS v2 provider
+	// alongside the local AES keys, e.g. from the `secrets-encrypt kms add` CLI.
+	// Empty means no KMS provider is configured.
+	EncryptionKMSName     string
+	EncryptionKMSEndpoint string
+
+	// EncryptionKMSTimeout bounds how long a call to the KMS plugin may take.
+	EncryptionKMSTimeout time.Duration
+
+	// EncryptionReencryptNamespaces, when non-empty, narrows updateSecrets to only
+	// these namespaces instead of the whole cluster.
+	EncryptionReencryptNamespaces []string
+
+	// EncryptionReencryptExcludeNamespaces is skipped by updateSecrets even when
+	// EncryptionReencryptNamespaces would otherwise include it, e.g. to permanently
+	// leave a large Helm release's secret store on the previous key.
+	EncryptionReencryptExcludeNamespaces []string
+
+	// EncryptionReencryptSelector, when set, further narrows updateSecrets to
+	// secrets matching this label selector.
+	EncryptionReencryptSelector string
+}
+
+// ControlRuntime holds paths and handles set up once a server starts.
+type ControlRuntime struct {
+	// EncryptionConfig is the path to the apiserver's EncryptionConfiguration file.
+	EncryptionConfig string
+
+	// ClusterControllerStarts holds controller start functions, keyed by name, that
+	// must run only once on the elected cluster leader rather than on every server
+	// -- e.g. the scheduled encryption key rotator.
+	ClusterControllerStarts map[string]func(ctx context.Context)
+}
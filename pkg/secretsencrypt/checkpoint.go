@@ -0,0 +1,137 @@
+package secretsencrypt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// checkpointSecretName and checkpointNamespace locate the well-known Secret
+	// that persists reencrypt progress across a controller restart.
+	checkpointSecretName string = "k3s-reencrypt-checkpoint"
+	checkpointNamespace  string = metav1.NamespaceSystem
+	checkpointDataKey    string = "checkpoint"
+)
+
+// reencryptCheckpoint is the progress record for an in-flight updateSecrets walk.
+type reencryptCheckpoint struct {
+	// KeyHash is the EncryptionReencryptActive hash the checkpoint was taken under;
+	// a checkpoint whose hash doesn't match the current active hash is stale and
+	// must not be resumed.
+	KeyHash string `json:"keyHash"`
+	// NamespaceIndex is the offset into the ordered list of namespaces being
+	// walked (see handler.reencryptNamespaces), so a scoped reencrypt resumes in
+	// the same namespace it was interrupted in.
+	NamespaceIndex int `json:"namespaceIndex"`
+	// Continue is the apiserver list continuation token for the next page.
+	Continue string `json:"continue"`
+	// ResourceVersion is the resourceVersion the list was started at.
+	ResourceVersion string `json:"resourceVersion"`
+	// Processed is the count of secrets already reencrypted.
+	Processed int64 `json:"processed"`
+}
+
+// getCheckpoint returns the current reencrypt checkpoint, or nil if none exists.
+func (h *handler) getCheckpoint() (*reencryptCheckpoint, error) {
+	secret, err := h.secrets.Get(checkpointNamespace, checkpointSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &reencryptCheckpoint{}
+	if err := json.Unmarshal(secret.Data[checkpointDataKey], cp); err != nil {
+		return nil, fmt.Errorf("failed to parse reencrypt checkpoint: %v", err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint creates or updates the checkpoint Secret with the given progress.
+func (h *handler) saveCheckpoint(cp *reencryptCheckpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := h.secrets.Get(checkpointNamespace, checkpointSecretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = h.secrets.Create(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      checkpointSecretName,
+					Namespace: checkpointNamespace,
+				},
+				Data: map[string][]byte{checkpointDataKey: data},
+			})
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		secret.Data = map[string][]byte{checkpointDataKey: data}
+		_, err = h.secrets.Update(secret)
+		return err
+	})
+}
+
+// deleteCheckpoint removes the checkpoint Secret, if any. It is called once a
+// reencrypt walk completes successfully, or when an operator skips the reencrypt.
+func (h *handler) deleteCheckpoint() error {
+	err := h.secrets.Delete(checkpointNamespace, checkpointSecretName, &metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// resumeReencrypt is called once on controller startup. If a checkpoint exists
+// whose key hash matches the currently active reencrypt, and a control-plane node
+// is still annotated as EncryptionReencryptActive for that hash, the walk is
+// resumed from where it left off instead of requiring an operator to re-trigger it.
+func (h *handler) resumeReencrypt() error {
+	cp, err := h.getCheckpoint()
+	if err != nil || cp == nil {
+		return err
+	}
+
+	activeHash, err := GenProviderReencryptHash(h.controlConfig.Runtime, EncryptionReencryptActive)
+	if err != nil {
+		return err
+	}
+	if cp.KeyHash != activeHash {
+		logrus.Infof("Discarding stale reencrypt checkpoint for a previous key set")
+		return h.deleteCheckpoint()
+	}
+
+	labelSelector := labels.Set{controlPlaneRoleLabelKey: "true"}.String()
+	nodes, err := h.nodes.List(metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes.Items {
+		ann, ok := n.Annotations[EncryptionHashAnnotation]
+		if !ok {
+			continue
+		}
+		split := strings.Split(ann, "-")
+		if len(split) == 2 && split[0] == EncryptionReencryptActive && split[1] == activeHash {
+			node := n.DeepCopy()
+			go func(nodeName string, node *corev1.Node) {
+				logrus.Infof("Resuming reencrypt on node %s from checkpoint (%d secrets already processed)", nodeName, cp.Processed)
+				if _, err := h.driveReencrypt(nodeName, node); err != nil {
+					logrus.Errorf("Failed to resume reencrypt from checkpoint: %v", err)
+				}
+			}(n.Name, node)
+			return nil
+		}
+	}
+	return nil
+}
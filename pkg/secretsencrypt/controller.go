@@ -4,30 +4,35 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/k3s-io/k3s/pkg/cluster"
 	"github.com/k3s-io/k3s/pkg/daemons/config"
 	"github.com/k3s-io/k3s/pkg/util"
 	coreclient "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
 
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/pager"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 )
 
 const (
-	controllerAgentName        string = "reencrypt-controller"
-	secretsUpdateStartEvent    string = "SecretsUpdateStart"
-	secretsProgressEvent       string = "SecretsProgress"
-	secretsUpdateCompleteEvent string = "SecretsUpdateComplete"
-	secretsUpdateErrorEvent    string = "SecretsUpdateError"
-	controlPlaneRoleLabelKey   string = "node-role.kubernetes.io/control-plane"
+	controllerAgentName         string = "reencrypt-controller"
+	secretsUpdateStartEvent     string = "SecretsUpdateStart"
+	secretsProgressEvent        string = "SecretsProgress"
+	secretsUpdateCompleteEvent  string = "SecretsUpdateComplete"
+	secretsUpdateErrorEvent     string = "SecretsUpdateError"
+	secretsReencryptScopedEvent string = "SecretsReencryptScoped"
+	controlPlaneRoleLabelKey    string = "node-role.kubernetes.io/control-plane"
+	defaultReencryptWorkers     int    = 5
+	progressEventInterval       int64  = 10
 )
 
 type handler struct {
@@ -54,6 +59,21 @@ func Register(
 	}
 
 	nodes.OnChange(ctx, "reencrypt-controller", h.onChangeNode)
+
+	if err := h.resumeReencrypt(); err != nil {
+		return err
+	}
+
+	if h.controlConfig.EncryptionKeyRenewPeriod > 0 {
+		// Registered rather than started directly: key rotation must run once per
+		// cluster, not once per server, so it only starts once this node is elected
+		// cluster leader.
+		if h.controlConfig.Runtime.ClusterControllerStarts == nil {
+			h.controlConfig.Runtime.ClusterControllerStarts = map[string]func(context.Context){}
+		}
+		h.controlConfig.Runtime.ClusterControllerStarts["secretsencrypt-key-rotation"] = h.runKeyRotation
+	}
+
 	return nil
 }
 
@@ -75,7 +95,7 @@ func (h *handler) onChangeNode(nodeName string, node *corev1.Node) (*corev1.Node
 		return node, nil
 	}
 
-	reencryptHash, err := GenReencryptHash(h.controlConfig.Runtime, EncryptionReencryptActive)
+	reencryptHash, err := GenProviderReencryptHash(h.controlConfig.Runtime, EncryptionReencryptActive)
 	if err != nil {
 		h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
 		return node, err
@@ -96,13 +116,35 @@ func (h *handler) onChangeNode(nodeName string, node *corev1.Node) (*corev1.Node
 		return node, err
 	}
 
-	if err := h.updateSecrets(node); err != nil {
+	return h.driveReencrypt(nodeName, node)
+}
+
+// driveReencrypt runs the remainder of the reencrypt state machine once a node's
+// annotation has been moved to EncryptionReencryptActive. Shared by onChangeNode
+// and resumeReencrypt, which restarts an interrupted walk from its checkpoint.
+func (h *handler) driveReencrypt(nodeName string, node *corev1.Node) (*corev1.Node, error) {
+	var err error
+
+	ann := node.Annotations[EncryptionHashAnnotation]
+	split := strings.Split(ann, "-")
+	if len(split) != 2 {
+		err := fmt.Errorf("invalid annotation %s found on node %s", ann, node.ObjectMeta.Name)
+		h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+		return node, err
+	}
+	activeHash := split[1]
+
+	if err := h.updateSecrets(node, activeHash); err != nil {
 		h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
 		return node, err
 	}
 
 	// If skipping, revert back to the previous stage
 	if h.controlConfig.EncryptSkip {
+		if err := h.deleteCheckpoint(); err != nil {
+			h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+			return node, err
+		}
 		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
 			node, err = h.nodes.Get(nodeName, metav1.GetOptions{})
 			if err != nil {
@@ -115,23 +157,57 @@ func (h *handler) onChangeNode(nodeName string, node *corev1.Node) (*corev1.Node
 		return node, err
 	}
 
-	// Remove last key
-	curKeys, err := GetEncryptionKeys(h.controlConfig.Runtime)
-	if err != nil {
-		h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+	// A scoped reencrypt (namespace list, exclude list or label selector)
+	// deliberately leaves some secrets encrypted with the previous key, so it
+	// must not trim that key yet. Mark the node EncryptionReencryptPartial
+	// instead of Finished; a follow-up full pass (with none of those scopes set)
+	// is required before the old key can be removed.
+	if h.reencryptIsPartialScope() {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err = h.nodes.Get(nodeName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			return WriteEncryptionHashAnnotation(h.controlConfig.Runtime, node, EncryptionReencryptPartial)
+		})
+		if err != nil {
+			h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+		}
 		return node, err
 	}
 
-	curKeys = curKeys[:len(curKeys)-1]
-	if err = WriteEncryptionConfig(h.controlConfig.Runtime, curKeys, true); err != nil {
-		h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
-		return node, err
-	}
-	logrus.Infoln("Removed key: ", curKeys[len(curKeys)-1])
-	if err != nil {
+	// If an operator marked a specific provider (e.g. a KMS provider being
+	// retired, or an AES provider named by its first key) for removal, honor
+	// that instead of blindly trimming the oldest local AES key.
+	if retiring, err := RetiringProvider(h.controlConfig.Runtime); err != nil {
 		h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
 		return node, err
+	} else if retiring != "" {
+		if err := RemoveProvider(h.controlConfig.Runtime, retiring); err != nil {
+			h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+			return node, err
+		}
+		if err := ClearRetiringProvider(h.controlConfig.Runtime); err != nil {
+			h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+			return node, err
+		}
+		logrus.Infoln("Removed retiring provider: ", retiring)
+	} else {
+		// Remove last key
+		curKeys, err := GetEncryptionKeys(h.controlConfig.Runtime)
+		if err != nil {
+			h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+			return node, err
+		}
+
+		curKeys = curKeys[:len(curKeys)-1]
+		if err = WriteEncryptionConfig(h.controlConfig.Runtime, curKeys, true); err != nil {
+			h.recorder.Event(node, corev1.EventTypeWarning, secretsUpdateErrorEvent, err.Error())
+			return node, err
+		}
+		logrus.Infoln("Removed key: ", curKeys[len(curKeys)-1])
 	}
+
 	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		node, err = h.nodes.Get(nodeName, metav1.GetOptions{})
 		if err != nil {
@@ -161,17 +237,33 @@ func (h *handler) validateReencryptStage(node *corev1.Node, annotation string) (
 	stage := split[0]
 	hash := split[1]
 
-	// Validate the specific stage and the request via sha256 hash
+	// Only a fresh EncryptionReencryptRequest kicks off work here. Active,
+	// Finished, and EncryptionReencryptPartial (a namespace-scoped reencrypt that
+	// deliberately left some secrets on the old key) are all terminal/in-progress
+	// states from this handler's point of view and fall through untouched; a
+	// Partial node can still accept a new Request to run the required follow-up
+	// full pass.
 	if stage != EncryptionReencryptRequest {
 		return false, nil
 	}
-	if reencryptRequestHash, err := GenReencryptHash(h.controlConfig.Runtime, EncryptionReencryptRequest); err != nil {
+	if reencryptRequestHash, err := GenProviderReencryptHash(h.controlConfig.Runtime, EncryptionReencryptRequest); err != nil {
 		return false, err
 	} else if reencryptRequestHash != hash {
 		err = fmt.Errorf("invalid hash: %s found on node %s", hash, node.ObjectMeta.Name)
 		return false, err
 	}
-	reencryptActiveHash, err := GenReencryptHash(h.controlConfig.Runtime, EncryptionReencryptActive)
+	if active, err := h.reencryptActive(); err != nil {
+		return false, err
+	} else if active {
+		return false, fmt.Errorf("another reencrypt is already active")
+	}
+	return true, nil
+}
+
+// reencryptActive reports whether any control-plane node currently has a
+// reencrypt in the EncryptionReencryptActive stage for the current key set.
+func (h *handler) reencryptActive() (bool, error) {
+	reencryptActiveHash, err := GenProviderReencryptHash(h.controlConfig.Runtime, EncryptionReencryptActive)
 	if err != nil {
 		return false, err
 	}
@@ -186,33 +278,167 @@ func (h *handler) validateReencryptStage(node *corev1.Node, annotation string) (
 			if len(split) != 2 {
 				return false, fmt.Errorf("invalid annotation %s found on node %s", ann, node.ObjectMeta.Name)
 			}
-			stage := split[0]
-			hash := split[1]
-			if stage == EncryptionReencryptActive && hash == reencryptActiveHash {
-				return false, fmt.Errorf("another reencrypt is already active")
+			if split[0] == EncryptionReencryptActive && split[1] == reencryptActiveHash {
+				return true, nil
 			}
 		}
 	}
-	return true, nil
+	return false, nil
+}
+
+// secretWorkItem pairs a secret with the WaitGroup tracking its page, so the
+// producer can tell when every secret it handed out for a page has actually
+// been reencrypted before it checkpoints past that page.
+type secretWorkItem struct {
+	secret *corev1.Secret
+	done   *sync.WaitGroup
 }
 
-func (h *handler) updateSecrets(node *corev1.Node) error {
-	secretPager := pager.New(pager.SimplePageFunc(func(opts metav1.ListOptions) (runtime.Object, error) {
-		return h.secrets.List("", opts)
-	}))
-	i := 0
-	secretPager.EachListItem(h.ctx, metav1.ListOptions{}, func(obj runtime.Object) error {
-		if secret, ok := obj.(*corev1.Secret); ok {
-			if _, err := h.secrets.Update(secret); err != nil {
-				return fmt.Errorf("failed to reencrypted secret: %v", err)
+// updateSecrets walks every secret in scope and rewrites it so that it is
+// reencrypted with the active key. The list is paged by a single producer and
+// fanned out across a bounded pool of workers so that large clusters don't stall
+// the reencrypt state transition behind a fully serial walk. Progress is
+// checkpointed after every page so a restarted controller can resume the walk
+// from the last saved namespace and continue token instead of starting over.
+//
+// Scope defaults to every secret in every namespace, but can be narrowed via
+// EncryptionReencryptNamespaces/EncryptionReencryptExcludeNamespaces/
+// EncryptionReencryptSelector so operators can validate a reencrypt against a
+// subset of secrets, or permanently skip large or sensitive namespaces.
+func (h *handler) updateSecrets(node *corev1.Node, activeHash string) error {
+	workers := h.controlConfig.EncryptionReencryptWorkers
+	if workers <= 0 {
+		workers = defaultReencryptWorkers
+	}
+
+	namespaces := h.reencryptNamespaces()
+	exclude := h.reencryptExcludeSet()
+	if len(exclude) > 0 {
+		h.recorder.Event(node, corev1.EventTypeNormal, secretsReencryptScopedEvent,
+			"reencrypt is scoped to exclude one or more namespaces; secrets in those namespaces remain encrypted with the previous key until a follow-up full pass completes")
+	}
+
+	startIndex := 0
+	listOpts := metav1.ListOptions{LabelSelector: h.controlConfig.EncryptionReencryptSelector}
+	var processed int64
+
+	cp, err := h.getCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp != nil && cp.KeyHash == activeHash {
+		startIndex = cp.NamespaceIndex
+		// A checkpoint is only ever saved with a continue token (see below), and the
+		// apiserver rejects a List that supplies both a continue token and a
+		// resourceVersion, so resuming must replay the token alone.
+		listOpts.Continue = cp.Continue
+		processed = cp.Processed
+		logrus.Infof("Resuming reencrypt from checkpoint, %d secrets already processed", cp.Processed)
+	} else if cp != nil {
+		if err := h.deleteCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	eg, ctx := errgroup.WithContext(h.ctx)
+	secretCh := make(chan *secretWorkItem)
+
+	eg.Go(func() error {
+		defer close(secretCh)
+		for nsIndex := startIndex; nsIndex < len(namespaces); nsIndex++ {
+			opts := metav1.ListOptions{LabelSelector: listOpts.LabelSelector}
+			if nsIndex == startIndex {
+				opts.Continue = listOpts.Continue
 			}
-			if i != 0 && i%10 == 0 {
-				h.recorder.Eventf(node, corev1.EventTypeNormal, secretsProgressEvent, "reencrypted %d secrets", i)
+			for {
+				list, err := h.secrets.List(namespaces[nsIndex], opts)
+				if err != nil {
+					return err
+				}
+				// pageWG tracks the items of this page still in flight, so the
+				// checkpoint below is only persisted once every one of them has
+				// actually been reencrypted, not merely handed to a worker.
+				var pageWG sync.WaitGroup
+				for i := range list.Items {
+					if exclude[list.Items[i].Namespace] {
+						continue
+					}
+					if list.Items[i].Namespace == checkpointNamespace && list.Items[i].Name == checkpointSecretName {
+						// Never reencrypt our own progress marker out from under saveCheckpoint.
+						continue
+					}
+					pageWG.Add(1)
+					select {
+					case secretCh <- &secretWorkItem{secret: &list.Items[i], done: &pageWG}:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				if list.Continue == "" {
+					break
+				}
+				pageWG.Wait()
+				if err := h.saveCheckpoint(&reencryptCheckpoint{
+					KeyHash:         activeHash,
+					NamespaceIndex:  nsIndex,
+					Continue:        list.Continue,
+					ResourceVersion: list.ResourceVersion,
+					Processed:       atomic.LoadInt64(&processed),
+				}); err != nil {
+					return fmt.Errorf("failed to persist reencrypt checkpoint: %v", err)
+				}
+				opts = metav1.ListOptions{LabelSelector: listOpts.LabelSelector, Continue: list.Continue}
 			}
-			i++
 		}
 		return nil
 	})
-	h.recorder.Eventf(node, corev1.EventTypeNormal, secretsUpdateCompleteEvent, "completed reencrypt of %d secrets", i)
-	return nil
+
+	for i := 0; i < workers; i++ {
+		eg.Go(func() error {
+			for item := range secretCh {
+				secret := item.secret
+				updateErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+					current, err := h.secrets.Get(secret.Namespace, secret.Name, metav1.GetOptions{})
+					if err != nil {
+						return err
+					}
+					_, err = h.secrets.Update(current)
+					return err
+				})
+				ok, err := reencryptSecretErrKind(updateErr)
+				item.done.Done()
+				if err != nil {
+					return fmt.Errorf("failed to reencrypt secret %s/%s: %v", secret.Namespace, secret.Name, err)
+				}
+				if !ok {
+					continue
+				}
+				if n := atomic.AddInt64(&processed, 1); n%progressEventInterval == 0 {
+					h.recorder.Eventf(node, corev1.EventTypeNormal, secretsProgressEvent, "reencrypted %d secrets", n)
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	h.recorder.Eventf(node, corev1.EventTypeNormal, secretsUpdateCompleteEvent, "completed reencrypt of %d secrets", processed)
+	return h.deleteCheckpoint()
+}
+
+// reencryptSecretErrKind classifies the result of a single secret update: ok
+// reports whether it should count toward the processed total (a NotFound means
+// the secret was deleted mid-walk, which is not an error), and a non-nil err
+// means the whole walk must abort.
+func reencryptSecretErrKind(updateErr error) (ok bool, err error) {
+	switch {
+	case updateErr == nil:
+		return true, nil
+	case apierrors.IsNotFound(updateErr):
+		return false, nil
+	default:
+		return false, updateErr
+	}
 }
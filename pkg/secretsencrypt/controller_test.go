@@ -0,0 +1,47 @@
+package secretsencrypt
+
+import (
+	"errors"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestReencryptSecretErrKind(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantOK    bool
+		wantFatal bool
+	}{
+		{
+			name:   "success",
+			err:    nil,
+			wantOK: true,
+		},
+		{
+			name:   "deleted mid-walk is not fatal",
+			err:    apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "foo"),
+			wantOK: false,
+		},
+		{
+			name:      "other errors abort the walk",
+			err:       errors.New("conflict that retry gave up on"),
+			wantOK:    false,
+			wantFatal: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := reencryptSecretErrKind(tt.err)
+			if ok != tt.wantOK {
+				t.Errorf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if (err != nil) != tt.wantFatal {
+				t.Errorf("err = %v, wantFatal %v", err, tt.wantFatal)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package secretsencrypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	coreclient "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
+)
+
+// KeyType identifies which provider an encryption key belongs to. k3s's
+// controller-driven reencrypt only ever manages a single aescbc provider, but the
+// keys management API understands both so operators can provision an aesgcm
+// provider ahead of promoting it to primary.
+type KeyType string
+
+const (
+	AESCBCKeyType KeyType = "aescbc"
+	AESGCMKeyType KeyType = "aesgcm"
+)
+
+// readEncryptionConfig parses the on-disk EncryptionConfiguration into its typed
+// upstream representation so individual providers and keys can be inspected and
+// edited directly, instead of going through the prepend/trim-last helpers the
+// reencrypt controller uses.
+func readEncryptionConfig(runtime *config.ControlRuntime) (*apiserverconfigv1.EncryptionConfiguration, error) {
+	data, err := os.ReadFile(runtime.EncryptionConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption config: %v", err)
+	}
+	econf := &apiserverconfigv1.EncryptionConfiguration{}
+	if err := yaml.Unmarshal(data, econf); err != nil {
+		return nil, fmt.Errorf("failed to parse encryption config: %v", err)
+	}
+	return econf, nil
+}
+
+func writeTypedEncryptionConfig(runtime *config.ControlRuntime, econf *apiserverconfigv1.EncryptionConfiguration) error {
+	data, err := yaml.Marshal(econf)
+	if err != nil {
+		return fmt.Errorf("failed to marshal encryption config: %v", err)
+	}
+	return os.WriteFile(runtime.EncryptionConfig, data, 0600)
+}
+
+// secretsResourceConfig returns the ResourceConfiguration entry that covers secrets,
+// which is where k3s's reencrypt controller manages its keys.
+func secretsResourceConfig(econf *apiserverconfigv1.EncryptionConfiguration) (*apiserverconfigv1.ResourceConfiguration, error) {
+	for i := range econf.Resources {
+		for _, resource := range econf.Resources[i].Resources {
+			if resource == "secrets" {
+				return &econf.Resources[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no resource configuration found for secrets")
+}
+
+// ListKeys returns every key configured across all providers for secrets, in
+// priority order: the first key returned is the active (encrypting) key. A KMS
+// provider is listed as a single key carrying the provider's name.
+func ListKeys(runtime *config.ControlRuntime) ([]apiserverconfigv1.Key, error) {
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiserverconfigv1.Key
+	for _, provider := range rc.Providers {
+		keys = append(keys, providerKeys(provider)...)
+	}
+	return keys, nil
+}
+
+// AddKey adds a new named key to the aescbc or aesgcm provider. The key is
+// appended after the existing keys so it becomes available for decryption
+// immediately but is not used to encrypt until it is promoted with PromoteKey.
+func AddKey(runtime *config.ControlRuntime, name string, keyType KeyType) error {
+	if name == "" {
+		return fmt.Errorf("key name must not be empty")
+	}
+
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return err
+	}
+
+	for _, provider := range rc.Providers {
+		for _, existing := range providerKeys(provider) {
+			if existing.Name == name {
+				return fmt.Errorf("key %s already exists", name)
+			}
+		}
+	}
+
+	secret, err := randomKeySecret()
+	if err != nil {
+		return err
+	}
+	key := apiserverconfigv1.Key{Name: name, Secret: secret}
+
+	provider, err := findOrAppendProvider(rc, keyType)
+	if err != nil {
+		return err
+	}
+	switch keyType {
+	case AESGCMKeyType:
+		provider.AESGCM.Keys = append(provider.AESGCM.Keys, key)
+	default:
+		provider.AESCBC.Keys = append(provider.AESCBC.Keys, key)
+	}
+
+	return writeTypedEncryptionConfig(runtime, econf)
+}
+
+// RemoveKey deletes a named key from whichever provider holds it. At least one
+// key must remain across all providers, since that key is required to decrypt
+// any secret that has not yet been reencrypted with a newer key.
+func RemoveKey(runtime *config.ControlRuntime, name string) error {
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range rc.Providers {
+		provider := &rc.Providers[i]
+		if provider.AESCBC != nil {
+			provider.AESCBC.Keys, found = removeKeyByName(provider.AESCBC.Keys, name, found)
+		}
+		if provider.AESGCM != nil {
+			provider.AESGCM.Keys, found = removeKeyByName(provider.AESGCM.Keys, name, found)
+		}
+	}
+	if !found {
+		return fmt.Errorf("key %s not found", name)
+	}
+
+	if countKeys(rc) == 0 {
+		return fmt.Errorf("cannot remove key %s: at least one key must remain capable of decrypting existing data", name)
+	}
+
+	return writeTypedEncryptionConfig(runtime, econf)
+}
+
+// PromoteKey reorders providers so the named key becomes the first (active,
+// encrypting) key. It is a no-op if the key is already primary.
+func PromoteKey(runtime *config.ControlRuntime, name string) error {
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return err
+	}
+
+	for i := range rc.Providers {
+		provider := &rc.Providers[i]
+		if provider.AESCBC != nil && promoteKeyByName(provider.AESCBC.Keys, name) {
+			promoteProvider(rc, i)
+			return writeTypedEncryptionConfig(runtime, econf)
+		}
+		if provider.AESGCM != nil && promoteKeyByName(provider.AESGCM.Keys, name) {
+			promoteProvider(rc, i)
+			return writeTypedEncryptionConfig(runtime, econf)
+		}
+	}
+	return fmt.Errorf("key %s not found", name)
+}
+
+// TriggerReencrypt requests a reencrypt of all secrets by setting the bootstrap
+// EncryptionReencryptRequest annotation on every control-plane node, the same
+// entry point used by the existing secrets-encrypt rotate/reencrypt commands.
+// The reencrypt controller (onChangeNode) then drives the rest of the state
+// machine using whatever key ordering the caller just wrote to disk.
+func TriggerReencrypt(nodes coreclient.NodeController, runtime *config.ControlRuntime) error {
+	labelSelector := labels.Set{controlPlaneRoleLabelKey: "true"}.String()
+	nodeList, err := nodes.List(metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return err
+	}
+	for _, n := range nodeList.Items {
+		nodeName := n.Name
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := nodes.Get(nodeName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			return WriteEncryptionHashAnnotation(runtime, node, EncryptionReencryptRequest)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to request reencrypt on node %s: %v", nodeName, err)
+		}
+	}
+	return nil
+}
+
+func providerKeys(provider apiserverconfigv1.ProviderConfiguration) []apiserverconfigv1.Key {
+	if provider.AESCBC != nil {
+		return provider.AESCBC.Keys
+	}
+	if provider.AESGCM != nil {
+		return provider.AESGCM.Keys
+	}
+	// A KMS provider holds no local key material, but it is still a key "slot"
+	// capable of decrypting data encrypted under it, so it counts toward the
+	// at-least-one-key-remains invariant enforced by RemoveKey/RemoveProvider.
+	if provider.KMS != nil {
+		return []apiserverconfigv1.Key{{Name: provider.KMS.Name}}
+	}
+	return nil
+}
+
+func findOrAppendProvider(rc *apiserverconfigv1.ResourceConfiguration, keyType KeyType) (*apiserverconfigv1.ProviderConfiguration, error) {
+	for i := range rc.Providers {
+		provider := &rc.Providers[i]
+		if keyType == AESGCMKeyType && provider.AESGCM != nil {
+			return provider, nil
+		}
+		if keyType != AESGCMKeyType && provider.AESCBC != nil {
+			return provider, nil
+		}
+	}
+	var provider apiserverconfigv1.ProviderConfiguration
+	if keyType == AESGCMKeyType {
+		provider.AESGCM = &apiserverconfigv1.AESConfiguration{}
+	} else {
+		provider.AESCBC = &apiserverconfigv1.AESConfiguration{}
+	}
+	rc.Providers = append(rc.Providers, provider)
+	return &rc.Providers[len(rc.Providers)-1], nil
+}
+
+func removeKeyByName(keys []apiserverconfigv1.Key, name string, alreadyFound bool) ([]apiserverconfigv1.Key, bool) {
+	filtered := keys[:0]
+	for _, key := range keys {
+		if key.Name == name {
+			alreadyFound = true
+			continue
+		}
+		filtered = append(filtered, key)
+	}
+	return filtered, alreadyFound
+}
+
+// promoteProvider moves the provider at index i to the front of rc.Providers,
+// preserving the relative order of the rest. Promoting a key within a provider
+// that isn't itself first is not enough: the apiserver always encrypts with the
+// first provider's first key, so the provider has to move too.
+func promoteProvider(rc *apiserverconfigv1.ResourceConfiguration, i int) {
+	if i == 0 {
+		return
+	}
+	provider := rc.Providers[i]
+	copy(rc.Providers[1:i+1], rc.Providers[0:i])
+	rc.Providers[0] = provider
+}
+
+func promoteKeyByName(keys []apiserverconfigv1.Key, name string) bool {
+	for i, key := range keys {
+		if key.Name == name {
+			if i != 0 {
+				copy(keys[1:i+1], keys[0:i])
+				keys[0] = key
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func countKeys(rc *apiserverconfigv1.ResourceConfiguration) int {
+	count := 0
+	for _, provider := range rc.Providers {
+		count += len(providerKeys(provider))
+	}
+	return count
+}
+
+func randomKeySecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate encryption key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(secret), nil
+}
@@ -0,0 +1,166 @@
+package secretsencrypt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/k3s-io/k3s/pkg/daemons/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiserverconfigv1 "k8s.io/apiserver/pkg/apis/config/v1"
+)
+
+// retiringProviderSuffix names the sidecar file, alongside the EncryptionConfig,
+// that records which provider an operator has marked for retirement. A KMS (or
+// any other) provider can't simply be "the last key" the way a local AES key list
+// can, so retirement is tracked explicitly instead of inferred from list order.
+const retiringProviderSuffix = ".retiring-provider"
+
+// AddKMSProvider adds a KMS v2 provider fronting the given gRPC endpoint (Vault,
+// a cloud KMS plugin, etc.) ahead of the existing providers, so it becomes the
+// active provider for new encryption operations. Existing local AES providers are
+// left in place so secrets already encrypted under them can still be decrypted
+// until a reencrypt completes.
+func AddKMSProvider(runtime *config.ControlRuntime, name, endpoint string, timeout time.Duration) error {
+	if name == "" || endpoint == "" {
+		return fmt.Errorf("KMS provider name and endpoint are required")
+	}
+
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return err
+	}
+	for _, provider := range rc.Providers {
+		if provider.KMS != nil && provider.KMS.Name == name {
+			return fmt.Errorf("KMS provider %s already exists", name)
+		}
+	}
+
+	kms := &apiserverconfigv1.KMSConfiguration{
+		APIVersion: "v2",
+		Name:       name,
+		Endpoint:   endpoint,
+		Timeout:    &metav1.Duration{Duration: timeout},
+	}
+	rc.Providers = append([]apiserverconfigv1.ProviderConfiguration{{KMS: kms}}, rc.Providers...)
+
+	return writeTypedEncryptionConfig(runtime, econf)
+}
+
+// RemoveProvider removes a named provider -- KMS, aescbc, or aesgcm -- from the
+// secrets resource configuration, generalizing the controller's old "remove last
+// key" behavior so an operator can retire whichever provider they choose, not
+// only the oldest local AES key. A KMS provider is matched by its name; an aescbc
+// or aesgcm provider is matched only by the name of its first key, and is then
+// removed together with all of its keys.
+func RemoveProvider(runtime *config.ControlRuntime, name string) error {
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return err
+	}
+
+	kept := rc.Providers[:0]
+	removed := false
+	for _, provider := range rc.Providers {
+		if providerName(provider) == name {
+			removed = true
+			continue
+		}
+		kept = append(kept, provider)
+	}
+	if !removed {
+		return fmt.Errorf("provider %s not found", name)
+	}
+	rc.Providers = kept
+
+	remaining := 0
+	for _, provider := range rc.Providers {
+		remaining += len(providerKeys(provider))
+	}
+	if remaining == 0 {
+		return fmt.Errorf("cannot remove provider %s: at least one provider must remain capable of decrypting existing data", name)
+	}
+
+	return writeTypedEncryptionConfig(runtime, econf)
+}
+
+func providerName(provider apiserverconfigv1.ProviderConfiguration) string {
+	if provider.KMS != nil {
+		return provider.KMS.Name
+	}
+	for _, key := range providerKeys(provider) {
+		return key.Name
+	}
+	return ""
+}
+
+// MarkProviderRetiring records that the named provider should be removed once the
+// in-flight reencrypt finishes, instead of the controller blindly trimming
+// whichever provider happens to be last. driveReencrypt consults this marker.
+func MarkProviderRetiring(runtime *config.ControlRuntime, name string) error {
+	return os.WriteFile(runtime.EncryptionConfig+retiringProviderSuffix, []byte(name), 0600)
+}
+
+// RetiringProvider returns the name of the provider marked for retirement, or the
+// empty string if none is set.
+func RetiringProvider(runtime *config.ControlRuntime) (string, error) {
+	data, err := os.ReadFile(runtime.EncryptionConfig + retiringProviderSuffix)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ClearRetiringProvider removes the retirement marker once it has been acted on.
+func ClearRetiringProvider(runtime *config.ControlRuntime) error {
+	err := os.Remove(runtime.EncryptionConfig + retiringProviderSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// GenProviderReencryptHash generalizes GenReencryptHash to hash provider identity
+// rather than raw local key bytes, so that reconfiguring the KMS provider itself
+// (adding, removing, or pointing it at a different name/endpoint) produces a new
+// hash and triggers a reencrypt, the same way adding or removing a local AES key
+// does. It does NOT detect a key-version rotation performed entirely on the KMS
+// plugin side (e.g. a new key version in Vault/cloud KMS): k3s never observes
+// that rotation's key id here, so such rotations must still be driven by an
+// explicit TriggerReencrypt.
+func GenProviderReencryptHash(runtime *config.ControlRuntime, stage string) (string, error) {
+	econf, err := readEncryptionConfig(runtime)
+	if err != nil {
+		return "", err
+	}
+	rc, err := secretsResourceConfig(econf)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(stage))
+	for _, provider := range rc.Providers {
+		if provider.KMS != nil {
+			fmt.Fprintf(h, "kms:%s:%s", provider.KMS.Name, provider.KMS.Endpoint)
+			continue
+		}
+		for _, key := range providerKeys(provider) {
+			fmt.Fprintf(h, "key:%s", key.Name)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
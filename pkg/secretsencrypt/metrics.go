@@ -0,0 +1,20 @@
+package secretsencrypt
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// lastRotationTimestamp records when the periodic key rotator last generated
+	// and activated a new encryption key, for operators to alert on staleness.
+	lastRotationTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "k3s_secretsencrypt_last_rotation_timestamp_seconds",
+		Help: "Unix timestamp of the last automatic encryption key rotation",
+	})
+	// nextRotationTimestamp records when the periodic key rotator is next due to run.
+	nextRotationTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "k3s_secretsencrypt_next_rotation_timestamp_seconds",
+		Help: "Unix timestamp of the next scheduled automatic encryption key rotation",
+	})
+)
@@ -0,0 +1,40 @@
+package secretsencrypt
+
+// EncryptionReencryptPartial marks a node that finished a namespace/selector-scoped
+// reencrypt. driveReencrypt writes it in place of EncryptionReencryptFinished, and
+// without trimming the retiring key/provider, whenever reencryptIsPartialScope is
+// true; the trim waits for an unscoped follow-up pass.
+const EncryptionReencryptPartial = "reencrypt_partial"
+
+// reencryptNamespaces returns the ordered list of namespaces updateSecrets should
+// walk. An empty EncryptionReencryptNamespaces means "every namespace", expressed
+// as a single empty-string namespace (the List API's convention for cluster-wide).
+func (h *handler) reencryptNamespaces() []string {
+	if len(h.controlConfig.EncryptionReencryptNamespaces) == 0 {
+		return []string{""}
+	}
+	return h.controlConfig.EncryptionReencryptNamespaces
+}
+
+// reencryptExcludeSet returns the set of namespaces updateSecrets should skip,
+// e.g. kube-system or a large Helm release's secret store.
+func (h *handler) reencryptExcludeSet() map[string]bool {
+	if len(h.controlConfig.EncryptionReencryptExcludeNamespaces) == 0 {
+		return nil
+	}
+	exclude := make(map[string]bool, len(h.controlConfig.EncryptionReencryptExcludeNamespaces))
+	for _, ns := range h.controlConfig.EncryptionReencryptExcludeNamespaces {
+		exclude[ns] = true
+	}
+	return exclude
+}
+
+// reencryptIsPartialScope reports whether this reencrypt is deliberately scoped to
+// less than the whole cluster, such that it must not be allowed to trim the
+// retiring key/provider once it finishes: secrets outside the scope are still
+// encrypted under the old key and need a follow-up full pass first.
+func (h *handler) reencryptIsPartialScope() bool {
+	return len(h.controlConfig.EncryptionReencryptNamespaces) > 0 ||
+		len(h.controlConfig.EncryptionReencryptExcludeNamespaces) > 0 ||
+		h.controlConfig.EncryptionReencryptSelector != ""
+}